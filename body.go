@@ -0,0 +1,181 @@
+package cbsdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"strings"
+)
+
+// Body encodes a request payload. Implementations decide both the wire
+// format and the Content-Type header, so Create no longer has to guess from
+// the underlying Go type. Any SdkRequest.GetBody() value that implements
+// Body is used as-is; anything else falls back to the original
+// map[string]interface{}/string JSON handling for compatibility.
+type Body interface {
+	ContentType() string
+	Reader() (io.Reader, error)
+}
+
+// singleUseBody is implemented by Body values whose Reader wraps a live,
+// single-use io.Reader (a stream, a file already being copied from). Calling
+// Reader() a second time after a failed attempt would send an empty or
+// truncated payload instead of the original one, so makeRequest refuses to
+// retry these rather than risk silent data corruption.
+type singleUseBody interface {
+	singleUse()
+}
+
+type jsonBody struct {
+	value interface{}
+}
+
+// JSONBody marshals v as JSON, the same encoding Create already applies to
+// a plain map[string]interface{} body, but usable alongside the other Body
+// implementations and on any HTTP method.
+func JSONBody(v interface{}) Body {
+	return jsonBody{value: v}
+}
+
+func (b jsonBody) ContentType() string {
+	return "application/json"
+}
+
+func (b jsonBody) Reader() (io.Reader, error) {
+	data, e := json.Marshal(b.value)
+	if e != nil {
+		return nil, e
+	}
+	return bytes.NewReader(data), nil
+}
+
+type formBody url.Values
+
+// FormBody encodes values as application/x-www-form-urlencoded, as required
+// by most OAuth token endpoints.
+func FormBody(values url.Values) Body {
+	return formBody(values)
+}
+
+func (b formBody) ContentType() string {
+	return "application/x-www-form-urlencoded"
+}
+
+func (b formBody) Reader() (io.Reader, error) {
+	return strings.NewReader(url.Values(b).Encode()), nil
+}
+
+type rawBody []byte
+
+// RawBody sends data as-is with Content-Type application/octet-stream.
+func RawBody(data []byte) Body {
+	return rawBody(data)
+}
+
+func (b rawBody) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (b rawBody) Reader() (io.Reader, error) {
+	return bytes.NewReader(b), nil
+}
+
+type streamBody struct {
+	reader      io.Reader
+	contentType string
+}
+
+// StreamBody passes r straight through to the request without buffering it
+// in memory, under the given contentType.
+func StreamBody(r io.Reader, contentType string) Body {
+	return streamBody{reader: r, contentType: contentType}
+}
+
+func (b streamBody) ContentType() string {
+	return b.contentType
+}
+
+func (b streamBody) Reader() (io.Reader, error) {
+	return b.reader, nil
+}
+
+func (b streamBody) singleUse() {}
+
+// MultipartBody builds a multipart/form-data payload, for endpoints that
+// take file uploads alongside regular fields. Build one with
+// NewMultipartBody, add content with AddField/AddFile, then pass it to
+// GetBody like any other Body.
+type MultipartBody struct {
+	fields []multipartField
+	files  []multipartFile
+	writer *multipart.Writer
+}
+
+type multipartField struct {
+	name, value string
+}
+
+type multipartFile struct {
+	field, filename string
+	content         io.Reader
+}
+
+func NewMultipartBody() *MultipartBody {
+	return &MultipartBody{}
+}
+
+func (b *MultipartBody) AddField(name, value string) *MultipartBody {
+	b.fields = append(b.fields, multipartField{name: name, value: value})
+	return b
+}
+
+// AddFile attaches content (e.g. an *os.File or any io.Reader) as field
+// under filename. content is streamed, not buffered whole.
+func (b *MultipartBody) AddFile(field, filename string, content io.Reader) *MultipartBody {
+	b.files = append(b.files, multipartFile{field: field, filename: filename, content: content})
+	return b
+}
+
+func (b *MultipartBody) ContentType() string {
+	return "multipart/form-data; boundary=" + b.boundary()
+}
+
+func (b *MultipartBody) Reader() (io.Reader, error) {
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+	b.writer = w
+
+	go func() {
+		for _, field := range b.fields {
+			if e := w.WriteField(field.name, field.value); e != nil {
+				pw.CloseWithError(e)
+				return
+			}
+		}
+		for _, file := range b.files {
+			part, e := w.CreateFormFile(file.field, file.filename)
+			if e != nil {
+				pw.CloseWithError(e)
+				return
+			}
+			if _, e := io.Copy(part, file.content); e != nil {
+				pw.CloseWithError(e)
+				return
+			}
+		}
+		pw.CloseWithError(w.Close())
+	}()
+
+	return pr, nil
+}
+
+func (b *MultipartBody) singleUse() {}
+
+func (b *MultipartBody) boundary() string {
+	if b.writer == nil {
+		b.writer = multipart.NewWriter(io.Discard)
+	}
+	return b.writer.Boundary()
+}
@@ -0,0 +1,150 @@
+package cbsdk
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by Create/CreateResponse when a configured
+// circuit breaker has tripped and is short-circuiting calls.
+var ErrCircuitOpen = errors.New("cbsdk: circuit breaker open")
+
+// BreakerConfig configures an optional circuit breaker that protects a
+// struggling backend from being piled on with more requests.
+type BreakerConfig struct {
+	FailureThreshold int
+	OpenDuration     time.Duration
+	HalfOpenProbes   int
+}
+
+// RateLimit configures an optional token-bucket rate limiter.
+type RateLimit struct {
+	RPS   float64
+	Burst int
+}
+
+// SetBreaker enables a circuit breaker for every subsequent Create call on
+// this Sdk, tracking consecutive failures against one backend host.
+func (a *Sdk) SetBreaker(cfg BreakerConfig) {
+	a.breaker = newCircuitBreaker(cfg)
+}
+
+// SetRateLimit enables a token-bucket rate limiter for every subsequent
+// Create call on this Sdk.
+func (a *Sdk) SetRateLimit(cfg RateLimit) {
+	a.limiter = newRateLimiter(cfg)
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type circuitBreaker struct {
+	mu                  sync.Mutex
+	cfg                 BreakerConfig
+	state               breakerState
+	consecutiveFailures int
+	openedAt            time.Time
+	halfOpenInFlight    int
+}
+
+func newCircuitBreaker(cfg BreakerConfig) *circuitBreaker {
+	return &circuitBreaker{cfg: cfg}
+}
+
+// allow reports whether a call may proceed, transitioning open -> half-open
+// once cfg.OpenDuration has elapsed.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		if time.Since(b.openedAt) < b.cfg.OpenDuration {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.halfOpenInFlight = 0
+	}
+
+	if b.state == breakerHalfOpen {
+		if b.halfOpenInFlight >= b.cfg.HalfOpenProbes {
+			return false
+		}
+		b.halfOpenInFlight++
+	}
+
+	return true
+}
+
+// recordResult feeds the outcome of a call back into the breaker.
+func (b *circuitBreaker) recordResult(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if failed {
+		if b.state == breakerHalfOpen || b.consecutiveFailures+1 >= b.cfg.FailureThreshold {
+			b.state = breakerOpen
+			b.openedAt = time.Now()
+			b.consecutiveFailures = 0
+			b.halfOpenInFlight = 0
+			return
+		}
+		b.consecutiveFailures++
+		return
+	}
+
+	b.state = breakerClosed
+	b.consecutiveFailures = 0
+	b.halfOpenInFlight = 0
+}
+
+// rateLimiter is a simple token bucket that blocks wait() callers until a
+// token is available or ctx is done.
+type rateLimiter struct {
+	mu         sync.Mutex
+	rps        float64
+	burst      float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+func newRateLimiter(cfg RateLimit) *rateLimiter {
+	return &rateLimiter{
+		rps:        cfg.RPS,
+		burst:      float64(cfg.Burst),
+		tokens:     float64(cfg.Burst),
+		lastRefill: time.Now(),
+	}
+}
+
+func (r *rateLimiter) wait(ctx context.Context) error {
+	for {
+		r.mu.Lock()
+		now := time.Now()
+		elapsed := now.Sub(r.lastRefill).Seconds()
+		r.tokens = math.Min(r.burst, r.tokens+elapsed*r.rps)
+		r.lastRefill = now
+
+		if r.tokens >= 1 {
+			r.tokens--
+			r.mu.Unlock()
+			return nil
+		}
+
+		wait := time.Duration((1 - r.tokens) / r.rps * float64(time.Second))
+		r.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
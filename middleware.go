@@ -0,0 +1,215 @@
+package cbsdk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Handler performs a single HTTP round trip. It mirrors http.RoundTripper so
+// built-in and user middleware can be written as simple decorators.
+type Handler func(req *http.Request) (*http.Response, error)
+
+// Middleware wraps a Handler to add cross-cutting behaviour (auth, logging,
+// compression, metrics, ...) without forking the Sdk.
+type Middleware func(next Handler) Handler
+
+// Use registers a middleware. Middlewares run in the order they were added,
+// outermost first, wrapping the final round trip performed by the Sdk's
+// underlying http.Client. Use is not safe to call concurrently with Create.
+func (a *Sdk) Use(mw Middleware) {
+	a.middlewares = append(a.middlewares, mw)
+}
+
+func (a *Sdk) handler(c call) Handler {
+	h := Handler(a.httpClient(c).Do)
+	for i := len(a.middlewares) - 1; i >= 0; i-- {
+		h = a.middlewares[i](h)
+	}
+	return h
+}
+
+type debugContextKey struct{}
+
+// BearerAuthMiddleware sets the Authorization header to "Bearer <token>" on
+// every outgoing request.
+func BearerAuthMiddleware(token string) Middleware {
+	return func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			req.Header.Set("Authorization", "Bearer "+token)
+			return next(req)
+		}
+	}
+}
+
+// BasicAuthMiddleware sets HTTP basic auth credentials on every outgoing
+// request.
+func BasicAuthMiddleware(username, password string) Middleware {
+	return func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			req.SetBasicAuth(username, password)
+			return next(req)
+		}
+	}
+}
+
+// GzipMiddleware compresses request bodies with gzip and transparently
+// decompresses gzip-encoded response bodies.
+func GzipMiddleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			if req.Body != nil && req.Body != http.NoBody {
+				body, e := ioutil.ReadAll(req.Body)
+				if e != nil {
+					return nil, e
+				}
+				req.Body.Close()
+
+				var buf bytes.Buffer
+				gw := gzip.NewWriter(&buf)
+				if _, e := gw.Write(body); e != nil {
+					return nil, e
+				}
+				if e := gw.Close(); e != nil {
+					return nil, e
+				}
+
+				req.Body = ioutil.NopCloser(&buf)
+				req.ContentLength = int64(buf.Len())
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			resp, e := next(req)
+			if e != nil {
+				return nil, e
+			}
+
+			if resp.Header.Get("Content-Encoding") == "gzip" {
+				gr, e := gzip.NewReader(resp.Body)
+				if e != nil {
+					return nil, e
+				}
+				resp.Body = &gzipReadCloser{Reader: gr, source: resp.Body}
+				resp.Header.Del("Content-Encoding")
+				resp.Header.Del("Content-Length")
+				resp.ContentLength = -1
+			}
+
+			return resp, nil
+		}
+	}
+}
+
+// gzipReadCloser decompresses a response body while still closing the
+// underlying network connection's body. gzip.Reader.Close() only validates
+// the stream trailer; it does not close the wrapped reader.
+type gzipReadCloser struct {
+	*gzip.Reader
+	source io.ReadCloser
+}
+
+func (g *gzipReadCloser) Close() error {
+	gzErr := g.Reader.Close()
+	srcErr := g.source.Close()
+	if gzErr != nil {
+		return gzErr
+	}
+	return srcErr
+}
+
+// Redactor scrubs sensitive data out of a logged request/response body
+// before it reaches LoggingMiddleware's logger.
+type Redactor func(body []byte) []byte
+
+// LoggingMiddleware logs each request and response, but only when the
+// originating call's debug flag is set. redact may be nil.
+func LoggingMiddleware(logger func(format string, args ...interface{}), redact Redactor) Middleware {
+	return func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			debug, _ := req.Context().Value(debugContextKey{}).(bool)
+			if !debug {
+				return next(req)
+			}
+
+			var reqBody []byte
+			if req.Body != nil {
+				reqBody, _ = ioutil.ReadAll(req.Body)
+				req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+			}
+			if redact != nil {
+				reqBody = redact(reqBody)
+			}
+			logger("cbsdk: %s %s body=%s", req.Method, req.URL, reqBody)
+
+			resp, e := next(req)
+			if e != nil {
+				logger("cbsdk: %s %s error=%s", req.Method, req.URL, e)
+				return nil, e
+			}
+
+			respBody, _ := ioutil.ReadAll(resp.Body)
+			resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+			if redact != nil {
+				respBody = redact(respBody)
+			}
+			logger("cbsdk: %s %s status=%d body=%s", req.Method, req.URL, resp.StatusCode, respBody)
+
+			return resp, nil
+		}
+	}
+}
+
+// RequestIDMiddleware sets header (commonly "X-Request-ID") to a random hex
+// identifier on every outgoing request that doesn't already carry one.
+func RequestIDMiddleware(header string) Middleware {
+	return func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get(header) == "" {
+				id, e := randomRequestID()
+				if e != nil {
+					return nil, e
+				}
+				req.Header.Set(header, id)
+			}
+			return next(req)
+		}
+	}
+}
+
+func randomRequestID() (string, error) {
+	buf := make([]byte, 16)
+	if _, e := rand.Read(buf); e != nil {
+		return "", e
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// MetricsMiddleware reports a request counter and duration histogram per
+// call, in the style of a Prometheus client. Either callback may be nil.
+func MetricsMiddleware(counter func(method string, statusCode int), histogram func(method string, duration time.Duration)) Middleware {
+	return func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, e := next(req)
+
+			if histogram != nil {
+				histogram(req.Method, time.Since(start))
+			}
+			if counter != nil {
+				status := 0
+				if resp != nil {
+					status = resp.StatusCode
+				}
+				counter(req.Method, status)
+			}
+
+			return resp, e
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package cbsdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Response is the structured result of a CreateResponse call, giving
+// callers access to the status code and headers alongside the raw body
+// instead of just a decoded string.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// DecodeJSON unmarshals the response body into v.
+func (r *Response) DecodeJSON(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// IsError reports whether the response status code is >= 400.
+func (r *Response) IsError() bool {
+	return r.StatusCode >= 400
+}
+
+// HTTPError is returned by CreateResponse when the server responds with a
+// status code >= 400, carrying enough context to let callers handle it
+// uniformly instead of re-inspecting the status code themselves.
+type HTTPError struct {
+	StatusCode int
+	Body       []byte
+	URL        string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("cbsdk: %s returned status %d: %s", e.URL, e.StatusCode, e.Body)
+}
@@ -2,11 +2,15 @@ package cbsdk
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -17,17 +21,71 @@ const (
 	SdkMethodDelete = 4
 )
 
+// RetryPolicy controls how a Create call is retried when the request fails
+// or comes back with a retryable status code.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Retryable is consulted after every attempt except the last one. resp
+	// is nil when the attempt failed with a transport error. Returning false
+	// stops retrying.
+	Retryable func(resp *http.Response, err error) bool
+}
+
+// DefaultRetryPolicy retries 429s, 5xxs and network timeouts with
+// exponential backoff and jitter, making at most three attempts in all.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   200 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+		Retryable:   defaultRetryable,
+	}
+}
+
+func defaultRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, ErrCircuitOpen) {
+			return false
+		}
+		var netErr net.Error
+		if ok := isNetError(err, &netErr); ok {
+			return netErr.Timeout()
+		}
+		return true
+	}
+	if resp == nil {
+		return false
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+func isNetError(err error, target *net.Error) bool {
+	if netErr, ok := err.(net.Error); ok {
+		*target = netErr
+		return true
+	}
+	return false
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay * time.Duration(1<<uint(attempt-1))
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	return delay/2 + jitter
+}
+
 type Sdk struct {
-	timeout       time.Duration
-	debug         bool
-	httpMethod    uint8
 	defaultHeader map[string]string
-	header        map[string]string
 	host          string
-	uri           string
-	get           []GetParam
-	body          interface{}
-	lock          *sync.Mutex
+	client        *http.Client
+	retryPolicy   *RetryPolicy
+	middlewares   []Middleware
+	breaker       *circuitBreaker
+	limiter       *rateLimiter
 }
 
 type GetParam struct {
@@ -45,11 +103,23 @@ type SdkRequest interface {
 	GetTimeout() time.Duration
 }
 
+// call is the per-request execution context built once at the top of
+// CreateWithContext. Passing it by value down to the request helpers means a
+// single Sdk can serve any number of concurrent Create calls without locking.
+type call struct {
+	method  string
+	debug   bool
+	header  map[string]string
+	uri     string
+	get     []GetParam
+	body    interface{}
+	timeout time.Duration
+}
+
 func New(host string, defaultHeaders map[string]string) *Sdk {
 	return &Sdk{
 		host:          host,
 		defaultHeader: defaultHeaders,
-		lock:          &sync.Mutex{},
 	}
 }
 
@@ -57,194 +127,247 @@ func (a *Sdk) AddDefaultHeader(key, value string) {
 	a.defaultHeader[key] = value
 }
 
-func (a *Sdk) Create(request SdkRequest) (string, error) {
-	a.lock.Lock()
-
-	a.timeout = request.GetTimeout()
-	a.debug = request.GetDebug()
-	a.httpMethod = request.GetHttpMethod()
-	a.header = request.GetHeader()
-	a.uri = request.GetUri()
-	a.get = request.GetGet()
-	a.body = request.GetBody()
-
-	var response string
-	var e error
-
-	switch a.httpMethod {
-	case SdkMethodPost:
-		response, e = a.makePostRequest()
-	case SdkMethodGet:
-		response, e = a.makeGetRequest()
-	case SdkMethodPut:
-		response, e = a.makePutRequest()
-	case SdkMethodDelete:
-		response, e = a.makeDeleteRequest()
-	default:
-		panic("specify a valid http method")
-	}
-
-	a.lock.Unlock()
-
-	return response, e
+// SetClient lets the caller supply their own *http.Client, e.g. to share a
+// connection pool or install a custom http.RoundTripper. When unset, Create
+// falls back to a client built from the per-request timeout.
+func (a *Sdk) SetClient(client *http.Client) {
+	a.client = client
 }
 
-func (a *Sdk) addGetParams(request *http.Request) {
-	query := request.URL.Query()
-	for _, param := range a.get {
-		query.Add(param.Key, param.Value)
+// SetTransport installs a custom http.RoundTripper on the Sdk's own client
+// without requiring the caller to manage timeouts themselves.
+func (a *Sdk) SetTransport(rt http.RoundTripper) {
+	if a.client == nil {
+		a.client = &http.Client{}
 	}
-	request.URL.RawQuery = query.Encode()
+	a.client.Transport = rt
 }
 
-func (a *Sdk) addHeaders(request *http.Request) {
-	for key, value := range a.header {
-		request.Header.Set(key, value)
-	}
+// SetRetryPolicy enables retries for every subsequent Create call. Pass nil
+// to disable retries again.
+func (a *Sdk) SetRetryPolicy(policy *RetryPolicy) {
+	a.retryPolicy = policy
 }
 
-func (a *Sdk) makePostRequest() (string, error) {
-	url := strings.Join([]string{a.host, a.uri}, "")
-
-	client := &http.Client{
-		Timeout: a.timeout,
-	}
+func (a *Sdk) Create(request SdkRequest) (string, error) {
+	return a.CreateWithContext(context.Background(), request)
+}
 
-	var requestBody []byte
-	var e error
-	_, isStringMap := a.body.(map[string]interface{})
-	_, isString := a.body.(string)
-	if isStringMap {
-		requestBody, e = json.Marshal(a.body)
-		if e != nil {
-			return "", e
-		}
-	} else if isString {
-		requestBody = []byte(a.body.(string))
-	}
+// CreateResponse behaves like Create but returns the full *Response
+// (status code, headers, raw body) instead of just the body string, and
+// reports a *HTTPError when the server responds with a status >= 400.
+func (a *Sdk) CreateResponse(request SdkRequest) (*Response, error) {
+	return a.CreateResponseWithContext(context.Background(), request)
+}
 
-	request, e := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+// CreateWithContext behaves like Create but propagates ctx into the
+// underlying http.Request, allowing callers to cancel or time out in-flight
+// requests. Unlike the old implementation it reads request into a local
+// value once and never mutates shared state, so one Sdk can safely fan out
+// many concurrent calls.
+func (a *Sdk) CreateWithContext(ctx context.Context, request SdkRequest) (string, error) {
+	response, e := a.makeRequest(ctx, newCall(request))
 	if e != nil {
 		return "", e
 	}
+	return string(response.Body), nil
+}
 
-	a.addGetParams(request)
-	a.addHeaders(request)
-
-	request.Header.Set("Content-Type", "application/json")
+// CreateResponseWithContext behaves like CreateResponse but propagates ctx
+// into the underlying http.Request.
+func (a *Sdk) CreateResponseWithContext(ctx context.Context, request SdkRequest) (*Response, error) {
+	c := newCall(request)
 
-	response, e := client.Do(request)
+	response, e := a.makeRequest(ctx, c)
 	if e != nil {
-		return "", e
+		return nil, e
 	}
-
-	defer response.Body.Close()
-	body, e := ioutil.ReadAll(response.Body)
-	if e != nil {
-		return "", e
+	if response.IsError() {
+		return response, &HTTPError{
+			StatusCode: response.StatusCode,
+			Body:       response.Body,
+			URL:        a.host + c.uri,
+		}
 	}
-
-	return string(body), nil
+	return response, nil
 }
 
-func (a *Sdk) makeGetRequest() (string, error) {
-	url := strings.Join([]string{a.host, a.uri}, "")
-
-	client := &http.Client{
-		Timeout: a.timeout,
+func newCall(request SdkRequest) call {
+	c := call{
+		debug:   request.GetDebug(),
+		header:  request.GetHeader(),
+		uri:     request.GetUri(),
+		get:     request.GetGet(),
+		body:    request.GetBody(),
+		timeout: request.GetTimeout(),
 	}
 
-	request, e := http.NewRequest("GET", url, nil)
-	if e != nil {
-		return "", e
+	switch request.GetHttpMethod() {
+	case SdkMethodPost:
+		c.method = "POST"
+	case SdkMethodGet:
+		c.method = "GET"
+	case SdkMethodPut:
+		c.method = "PUT"
+	case SdkMethodDelete:
+		c.method = "DELETE"
+	default:
+		panic("specify a valid http method")
 	}
 
-	a.addGetParams(request)
-	a.addHeaders(request)
+	return c
+}
 
-	response, e := client.Do(request)
-	if e != nil {
-		return "", e
+func addGetParams(request *http.Request, params []GetParam) {
+	query := request.URL.Query()
+	for _, param := range params {
+		query.Add(param.Key, param.Value)
 	}
+	request.URL.RawQuery = query.Encode()
+}
 
-	defer response.Body.Close()
-	body, e := ioutil.ReadAll(response.Body)
-	if e != nil {
-		return "", e
+func addHeaders(request *http.Request, header map[string]string) {
+	for key, value := range header {
+		request.Header.Set(key, value)
 	}
-
-	return string(body), nil
 }
 
-func (a *Sdk) makePutRequest() (string, error) {
-	url := strings.Join([]string{a.host, a.uri}, "")
+func (a *Sdk) httpClient(c call) *http.Client {
+	if a.client != nil {
+		if a.client.Timeout == 0 && c.timeout > 0 {
+			client := *a.client
+			client.Timeout = c.timeout
+			return &client
+		}
+		return a.client
+	}
+	return &http.Client{Timeout: c.timeout}
+}
 
-	client := &http.Client{
-		Timeout: a.timeout,
+func requestBody(body interface{}) ([]byte, error) {
+	_, isStringMap := body.(map[string]interface{})
+	_, isString := body.(string)
+	switch {
+	case isStringMap:
+		return json.Marshal(body)
+	case isString:
+		return []byte(body.(string)), nil
+	default:
+		return nil, nil
 	}
+}
 
-	var requestBody []byte
-	var e error
-	_, isStringMap := a.body.(map[string]interface{})
-	_, isString := a.body.(string)
-	if isStringMap {
-		requestBody, e = json.Marshal(a.body)
-		if e != nil {
-			return "", e
+func (a *Sdk) buildRequest(ctx context.Context, c call) (*http.Request, error) {
+	url := strings.Join([]string{a.host, c.uri}, "")
+
+	var bodyReader io.Reader
+	contentType := ""
+	if c.body != nil {
+		if encoded, ok := c.body.(Body); ok {
+			r, e := encoded.Reader()
+			if e != nil {
+				return nil, e
+			}
+			bodyReader = r
+			contentType = encoded.ContentType()
+		} else {
+			raw, e := requestBody(c.body)
+			if e != nil {
+				return nil, e
+			}
+			if raw != nil {
+				bodyReader = bytes.NewBuffer(raw)
+				contentType = "application/json"
+			}
 		}
-	} else if isString {
-		requestBody = []byte(a.body.(string))
 	}
 
-	request, e := http.NewRequest("PUT", url, bytes.NewBuffer(requestBody))
+	ctx = context.WithValue(ctx, debugContextKey{}, c.debug)
+	request, e := http.NewRequestWithContext(ctx, c.method, url, bodyReader)
 	if e != nil {
-		return "", e
+		return nil, e
 	}
 
-	a.addGetParams(request)
-	a.addHeaders(request)
+	addGetParams(request, c.get)
+	addHeaders(request, c.header)
 
-	request.Header.Set("Content-Type", "application/json")
-
-	response, e := client.Do(request)
-	if e != nil {
-		return "", e
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
 	}
 
-	defer response.Body.Close()
-	body, e := ioutil.ReadAll(response.Body)
-	if e != nil {
-		return "", e
-	}
-
-	return string(body), nil
+	return request, nil
 }
 
-func (a *Sdk) makeDeleteRequest() (string, error) {
-	url := strings.Join([]string{a.host, a.uri}, "")
-
-	client := &http.Client{
-		Timeout: a.timeout,
+// makeRequest performs a single logical call, retrying according to
+// a.retryPolicy when the attempt fails or returns a retryable status code.
+func (a *Sdk) makeRequest(ctx context.Context, c call) (*Response, error) {
+	policy := a.retryPolicy
+	attempts := 1
+	if policy != nil && policy.MaxAttempts > attempts {
+		attempts = policy.MaxAttempts
 	}
-
-	request, e := http.NewRequest("DELETE", url, nil)
-	if e != nil {
-		return "", e
+	if _, singleUse := c.body.(singleUseBody); singleUse {
+		// The body's Reader() can only be drained once, so resending it on
+		// a retry would silently transmit an empty or truncated payload.
+		attempts = 1
 	}
 
-	a.addGetParams(request)
-	a.addHeaders(request)
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if a.limiter != nil {
+			if e := a.limiter.wait(ctx); e != nil {
+				return nil, e
+			}
+		}
 
-	response, e := client.Do(request)
-	if e != nil {
-		return "", e
-	}
+		var httpResponse *http.Response
+		var e error
+		if a.breaker != nil && !a.breaker.allow() {
+			e = ErrCircuitOpen
+		} else {
+			var request *http.Request
+			request, e = a.buildRequest(ctx, c)
+			if e != nil {
+				return nil, e
+			}
+
+			httpResponse, e = a.handler(c)(request)
+			if e == nil {
+				defer httpResponse.Body.Close()
+			}
+
+			if a.breaker != nil {
+				a.breaker.recordResult(e != nil || (httpResponse != nil && httpResponse.StatusCode >= 500))
+			}
+		}
 
-	defer response.Body.Close()
-	body, e := ioutil.ReadAll(response.Body)
-	if e != nil {
-		return "", e
+		retry := policy != nil && attempt < attempts && policy.Retryable(httpResponse, e)
+		if !retry {
+			if e != nil {
+				return nil, e
+			}
+			body, readErr := ioutil.ReadAll(httpResponse.Body)
+			if readErr != nil {
+				return nil, readErr
+			}
+			return &Response{
+				StatusCode: httpResponse.StatusCode,
+				Header:     httpResponse.Header,
+				Body:       body,
+			}, nil
+		}
+
+		lastErr = e
+		if e == nil {
+			ioutil.ReadAll(httpResponse.Body)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(policy.backoff(attempt)):
+		}
 	}
 
-	return string(body), nil
+	return nil, lastErr
 }